@@ -17,13 +17,26 @@ const (
 	maxBucketSize = 12
 	minMapSize    = iniCapacity * midLoadFactor
 
+	// evacuatePerOp bounds how many old buckets a single Store or Delete
+	// moves into the new table while a resize is in flight, so that no
+	// single call pays for the whole resize.
+	evacuatePerOp = 2
+
 	possible   = 0
 	impossible = 1
 )
 
 type Map struct {
-	mu     sync.Mutex
-	hm     atomic.Value // *hmap.Map
+	mu sync.Mutex
+	hm atomic.Value // *hmap.Map, the live table
+
+	// oldHM and nevacuate implement an incremental, non-stop-the-world
+	// resize modeled on the Go runtime map's evacuation scheme: oldHM holds
+	// the table being migrated out of (nil when no resize is in flight),
+	// and nevacuate is the index of the next oldHM bucket to move into hm.
+	oldHM     atomic.Value // *hmap.Map
+	nevacuate uint
+
 	resize uint32
 	hasher func(key interface{}) uint32
 }
@@ -39,6 +52,7 @@ func DefaultHasher(key interface{}) uint32 {
 func NewMap(hasher func(key interface{}) uint32) (m *Map) {
 	m = &Map{hasher: hasher}
 	m.hm.Store(hmap.NewMap(iniCapacity, hasher))
+	m.oldHM.Store((*hmap.Map)(nil))
 	return
 }
 
@@ -46,7 +60,12 @@ func NewMap(hasher func(key interface{}) uint32) (m *Map) {
 // exists. Otherwise, it returns nil and false.
 func (m *Map) Load(key interface{}) (value interface{}, ok bool) {
 	hm := m.hm.Load().(*hmap.Map)
-	value, ok = hm.Load(key)
+	if value, ok = hm.Load(key); ok {
+		return
+	}
+	if old := m.oldHM.Load().(*hmap.Map); old != nil {
+		value, ok = old.Load(key)
+	}
 	return
 }
 
@@ -55,6 +74,7 @@ func (m *Map) Store(key, value interface{}) {
 	m.mu.Lock()
 	hm := m.hm.Load().(*hmap.Map)
 	hm.Store(key, value)
+	m.evacuateSome()
 	m.resizeIfNeeded()
 	m.mu.Unlock()
 }
@@ -64,48 +84,209 @@ func (m *Map) Delete(key interface{}) {
 	m.mu.Lock()
 	hm := m.hm.Load().(*hmap.Map)
 	hm.Delete(key)
+	if old := m.oldHM.Load().(*hmap.Map); old != nil {
+		old.Delete(key)
+	}
+	m.evacuateSome()
+	m.resizeIfNeeded()
+	m.mu.Unlock()
+}
+
+// LoadAndDelete removes the value for a key, returning the previous value if
+// any. The loaded result reports whether the key was present.
+func (m *Map) LoadAndDelete(key interface{}) (value interface{}, loaded bool) {
+	m.mu.Lock()
+	value, loaded = m.Load(key)
+	hm := m.hm.Load().(*hmap.Map)
+	hm.Delete(key)
+	if old := m.oldHM.Load().(*hmap.Map); old != nil {
+		old.Delete(key)
+	}
+	m.evacuateSome()
 	m.resizeIfNeeded()
 	m.mu.Unlock()
+	return
+}
+
+// Swap sets the value for a key and returns the previous value if any. The
+// loaded result reports whether the key was present.
+func (m *Map) Swap(key, value interface{}) (previous interface{}, loaded bool) {
+	m.mu.Lock()
+	previous, loaded = m.Load(key)
+	hm := m.hm.Load().(*hmap.Map)
+	hm.Store(key, value)
+	m.evacuateSome()
+	m.resizeIfNeeded()
+	m.mu.Unlock()
+	return
+}
+
+// CompareAndSwap swaps the old and new values for a key if the value stored
+// in the map is equal to old. The old value must be of a comparable type.
+func (m *Map) CompareAndSwap(key, old, new interface{}) (swapped bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	current, loaded := m.Load(key)
+	if !loaded || current != old {
+		return false
+	}
+	hm := m.hm.Load().(*hmap.Map)
+	hm.Store(key, new)
+	m.evacuateSome()
+	m.resizeIfNeeded()
+	return true
+}
+
+// CompareAndDelete deletes the entry for a key if its value is equal to old.
+// The old value must be of a comparable type.
+func (m *Map) CompareAndDelete(key, old interface{}) (deleted bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	current, loaded := m.Load(key)
+	if !loaded || current != old {
+		return false
+	}
+	hm := m.hm.Load().(*hmap.Map)
+	hm.Delete(key)
+	if oldHM := m.oldHM.Load().(*hmap.Map); oldHM != nil {
+		oldHM.Delete(key)
+	}
+	m.evacuateSome()
+	m.resizeIfNeeded()
+	return true
+}
+
+// Compute runs remapping atomically with respect to other writers: it is
+// invoked while holding m's update mutex with the current value for key (and
+// whether it was present), and its result decides what happens next. If
+// delete is true, the key is removed; otherwise newValue is stored. Compute
+// lets callers implement counters, sets, and idempotent inserts without a
+// separate Load-then-Store that could race with a concurrent writer.
+func (m *Map) Compute(
+	key interface{},
+	remapping func(oldValue interface{}, loaded bool) (newValue interface{}, delete bool),
+) (actual interface{}, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	oldValue, loaded := m.Load(key)
+	newValue, del := remapping(oldValue, loaded)
+	hm := m.hm.Load().(*hmap.Map)
+	if del {
+		if loaded {
+			hm.Delete(key)
+			if oldHM := m.oldHM.Load().(*hmap.Map); oldHM != nil {
+				oldHM.Delete(key)
+			}
+			m.evacuateSome()
+			m.resizeIfNeeded()
+		}
+		return nil, false
+	}
+	hm.Store(key, newValue)
+	m.evacuateSome()
+	m.resizeIfNeeded()
+	return newValue, true
+}
+
+// Size returns the number of keys currently stored in the map.
+func (m *Map) Size() int {
+	hm := m.hm.Load().(*hmap.Map)
+	size := int(hm.Size())
+	if old := m.oldHM.Load().(*hmap.Map); old != nil {
+		old.Range(func(k, _ interface{}) bool {
+			if _, ok := hm.Load(k); !ok {
+				size++
+			}
+			return true
+		})
+	}
+	return size
 }
 
 // Range iteratively applies the given function to each key-value pair until
 // the function returns false.
 func (m *Map) Range(f func(key, value interface{}) bool) {
-	m.mu.Lock() // To ensure that no other process concurrently resizes the map.
+	m.mu.Lock() // To ensure that no other process concurrently resizes or evacuates the map.
 	atomic.StoreUint32(&m.resize, impossible)
 	m.mu.Unlock()
 
 	hm := m.hm.Load().(*hmap.Map)
-	hm.Range(f)
+	stopped := false
+	hm.Range(func(k, v interface{}) bool {
+		if !f(k, v) {
+			stopped = true
+			return false
+		}
+		return true
+	})
+
+	if old := m.oldHM.Load().(*hmap.Map); old != nil && !stopped {
+		old.Range(func(k, v interface{}) bool {
+			if _, ok := hm.Load(k); ok {
+				return true // already visible through the new table
+			}
+			return f(k, v)
+		})
+	}
 
 	atomic.StoreUint32(&m.resize, possible)
 }
 
+// evacuateSome moves a bounded number of buckets from oldHM into hm so that
+// the cost of a resize is spread across the Store and Delete calls that
+// follow it, instead of being paid up front by a single caller. This method
+// can only be issued inside the critical section.
+func (m *Map) evacuateSome() {
+	if atomic.LoadUint32(&m.resize) == impossible {
+		return
+	}
+	old := m.oldHM.Load().(*hmap.Map)
+	if old == nil {
+		return
+	}
+
+	hm := m.hm.Load().(*hmap.Map)
+	capacity, _ := old.StatBuckets()
+	for i := 0; i < evacuatePerOp && m.nevacuate < capacity; i++ {
+		old.RangeBucket(m.nevacuate, func(k, v interface{}) bool {
+			if _, ok := hm.Load(k); !ok {
+				hm.Store(k, v)
+			}
+			return true
+		})
+		m.nevacuate++
+	}
+
+	if m.nevacuate >= capacity {
+		m.oldHM.Store((*hmap.Map)(nil))
+		m.nevacuate = 0
+	}
+}
+
 // This method can only be issued inside the critical section.
 func (m *Map) resizeIfNeeded() {
-	resize := atomic.LoadUint32(&m.resize)
-	if resize == impossible {
+	if atomic.LoadUint32(&m.resize) == impossible {
 		return
 	}
+	if old := m.oldHM.Load().(*hmap.Map); old != nil {
+		return // a resize is already in flight
+	}
 
 	h := m.hm.Load().(*hmap.Map)
-	entries, _ := h.StatEntries()
+	size := h.Size()
 	buckets, largest := h.StatBuckets()
-	if entries < minMapSize {
+	if size < minMapSize {
 		return
 	}
-	LoadFactor := float32(entries) / float32(buckets)
-	tooSmallBuckets := LoadFactor > minLoadFactor
+	loadFactor := float32(size) / float32(buckets)
+	tooSmallBuckets := loadFactor > minLoadFactor
 	bucketOverflow := largest > maxBucketSize
 
 	if tooSmallBuckets || bucketOverflow {
 		newMapCap := 2*buckets - 1
 		newMap := hmap.NewMap(newMapCap, m.hasher)
-		oldMap := m.hm.Load().(*hmap.Map)
-		oldMap.Range(func(k, v interface{}) bool {
-			newMap.Store(k, v)
-			return true
-		})
+		m.oldHM.Store(h)
 		m.hm.Store(newMap)
+		m.nevacuate = 0
 	}
 }
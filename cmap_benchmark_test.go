@@ -13,6 +13,14 @@ const (
 	entries = 1 << 10
 )
 
+// mapIface is the subset of sync.Map's and cmap.Map's methods the benchmarks
+// below drive, so the same perG closures can run against either.
+type mapIface interface {
+	Load(key interface{}) (value interface{}, ok bool)
+	Store(key, value interface{})
+	Delete(key interface{})
+}
+
 type bench struct {
 	setup func(*testing.B, mapIface)
 	perG  func(b *testing.B, pb *testing.PB, m mapIface)
@@ -0,0 +1,202 @@
+package cmap_test
+
+import (
+	"testing"
+
+	"github.com/decillion/go-cmap"
+)
+
+func TestLoadStoreDelete(t *testing.T) {
+	m := cmap.NewMap(cmap.DefaultHasher)
+
+	if _, ok := m.Load("a"); ok {
+		t.Fatal("Load on empty map returned ok = true")
+	}
+
+	m.Store("a", 1)
+	if v, ok := m.Load("a"); !ok || v.(int) != 1 {
+		t.Fatalf("Load(%q) = %v, %v; want 1, true", "a", v, ok)
+	}
+
+	m.Store("a", 2)
+	if v, ok := m.Load("a"); !ok || v.(int) != 2 {
+		t.Fatalf("Load(%q) after overwrite = %v, %v; want 2, true", "a", v, ok)
+	}
+
+	m.Delete("a")
+	if _, ok := m.Load("a"); ok {
+		t.Fatal("Load after Delete returned ok = true")
+	}
+}
+
+func TestLoadAndDelete(t *testing.T) {
+	m := cmap.NewMap(cmap.DefaultHasher)
+	m.Store("a", 1)
+
+	v, loaded := m.LoadAndDelete("a")
+	if !loaded || v.(int) != 1 {
+		t.Fatalf("LoadAndDelete(%q) = %v, %v; want 1, true", "a", v, loaded)
+	}
+	if _, ok := m.Load("a"); ok {
+		t.Fatal("Load after LoadAndDelete returned ok = true")
+	}
+
+	if _, loaded := m.LoadAndDelete("a"); loaded {
+		t.Fatal("LoadAndDelete on absent key returned loaded = true")
+	}
+}
+
+func TestSwap(t *testing.T) {
+	m := cmap.NewMap(cmap.DefaultHasher)
+
+	prev, loaded := m.Swap("a", 1)
+	if loaded || prev != nil {
+		t.Fatalf("Swap on absent key = %v, %v; want nil, false", prev, loaded)
+	}
+
+	prev, loaded = m.Swap("a", 2)
+	if !loaded || prev.(int) != 1 {
+		t.Fatalf("Swap on present key = %v, %v; want 1, true", prev, loaded)
+	}
+	if v, _ := m.Load("a"); v.(int) != 2 {
+		t.Fatalf("Load after Swap = %v; want 2", v)
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	m := cmap.NewMap(cmap.DefaultHasher)
+	m.Store("a", 1)
+
+	if m.CompareAndSwap("a", 2, 3) {
+		t.Fatal("CompareAndSwap with mismatched old value reported swapped = true")
+	}
+	if v, _ := m.Load("a"); v.(int) != 1 {
+		t.Fatalf("Load after failed CompareAndSwap = %v; want 1", v)
+	}
+
+	if !m.CompareAndSwap("a", 1, 3) {
+		t.Fatal("CompareAndSwap with matching old value reported swapped = false")
+	}
+	if v, _ := m.Load("a"); v.(int) != 3 {
+		t.Fatalf("Load after successful CompareAndSwap = %v; want 3", v)
+	}
+
+	if m.CompareAndSwap("missing", 0, 1) {
+		t.Fatal("CompareAndSwap on absent key reported swapped = true")
+	}
+}
+
+func TestCompareAndDelete(t *testing.T) {
+	m := cmap.NewMap(cmap.DefaultHasher)
+	m.Store("a", 1)
+
+	if m.CompareAndDelete("a", 2) {
+		t.Fatal("CompareAndDelete with mismatched old value reported deleted = true")
+	}
+	if _, ok := m.Load("a"); !ok {
+		t.Fatal("key removed after failed CompareAndDelete")
+	}
+
+	if !m.CompareAndDelete("a", 1) {
+		t.Fatal("CompareAndDelete with matching old value reported deleted = false")
+	}
+	if _, ok := m.Load("a"); ok {
+		t.Fatal("key still present after successful CompareAndDelete")
+	}
+}
+
+func TestCompute(t *testing.T) {
+	m := cmap.NewMap(cmap.DefaultHasher)
+
+	actual, ok := m.Compute("a", func(old interface{}, loaded bool) (interface{}, bool) {
+		if loaded {
+			t.Fatal("remapping saw loaded = true for an absent key")
+		}
+		return 1, false
+	})
+	if !ok || actual.(int) != 1 {
+		t.Fatalf("Compute insert = %v, %v; want 1, true", actual, ok)
+	}
+
+	actual, ok = m.Compute("a", func(old interface{}, loaded bool) (interface{}, bool) {
+		if !loaded || old.(int) != 1 {
+			t.Fatalf("remapping saw old = %v, %v; want 1, true", old, loaded)
+		}
+		return old.(int) + 1, false
+	})
+	if !ok || actual.(int) != 2 {
+		t.Fatalf("Compute update = %v, %v; want 2, true", actual, ok)
+	}
+
+	actual, ok = m.Compute("a", func(old interface{}, loaded bool) (interface{}, bool) {
+		return nil, true
+	})
+	if ok || actual != nil {
+		t.Fatalf("Compute delete = %v, %v; want nil, false", actual, ok)
+	}
+	if _, ok := m.Load("a"); ok {
+		t.Fatal("key still present after Compute delete")
+	}
+}
+
+func TestSizeAndRange(t *testing.T) {
+	m := cmap.NewMap(cmap.DefaultHasher)
+	const n = 1000
+	want := make(map[int]int, n)
+	for i := 0; i < n; i++ {
+		m.Store(i, i*i)
+		want[i] = i * i
+	}
+
+	if got := m.Size(); got != n {
+		t.Fatalf("Size() = %d; want %d", got, n)
+	}
+
+	got := make(map[int]int, n)
+	m.Range(func(k, v interface{}) bool {
+		got[k.(int)] = v.(int)
+		return true
+	})
+	if len(got) != len(want) {
+		t.Fatalf("Range visited %d entries; want %d", len(got), len(want))
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Fatalf("Range entry for %d = %d; want %d", k, got[k], v)
+		}
+	}
+
+	for i := 0; i < n; i += 2 {
+		m.Delete(i)
+	}
+	if got := m.Size(); got != n/2 {
+		t.Fatalf("Size() after deleting half = %d; want %d", got, n/2)
+	}
+}
+
+// TestResizeSurvivesLookups stores enough keys to force at least one
+// incremental resize (minMapSize is iniCapacity*midLoadFactor = 64) and
+// checks that every key remains loadable throughout, exercising the
+// evacuateSome/resizeIfNeeded path alongside plain Store/Load.
+func TestResizeSurvivesLookups(t *testing.T) {
+	m := cmap.NewMap(cmap.DefaultHasher)
+	const n = 1 << 13
+
+	for i := 0; i < n; i++ {
+		m.Store(i, i)
+		for j := 0; j <= i; j += i/8 + 1 {
+			if v, ok := m.Load(j); !ok || v.(int) != j {
+				t.Fatalf("Load(%d) after storing %d keys = %v, %v; want %d, true", j, i+1, v, ok, j)
+			}
+		}
+	}
+
+	if got := m.Size(); got != n {
+		t.Fatalf("Size() = %d; want %d", got, n)
+	}
+	for i := 0; i < n; i++ {
+		if v, ok := m.Load(i); !ok || v.(int) != i {
+			t.Fatalf("Load(%d) = %v, %v; want %d, true", i, v, ok, i)
+		}
+	}
+}
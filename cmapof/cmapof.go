@@ -0,0 +1,202 @@
+// Package cmapof implements a generic, typed concurrent map. Each bucket
+// holds a singly linked list of entries, whose keys and values are of the
+// caller's own type parameters instead of interface{}, which avoids the
+// boxing hmap.entry pays for on every Store.
+package cmapof
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+)
+
+const iniCapacity = 1 << 4
+
+// MapOf is a non-resizable hash map whose keys and values are of type K and
+// V. Update operations serialize on the map's own mutex, so callers need no
+// external synchronization, and a single update operation can run
+// concurrently with multiple read operations. Store, Delete, LoadOrStore,
+// LoadAndDelete, and Compute are update operations; Load and Range are read
+// operations and take no lock.
+type MapOf[K comparable, V any] struct {
+	mu      sync.Mutex
+	hasher  func(key K, seed uint64) uint64
+	seed    uint64
+	buckets []*bucketOf[K, V]
+
+	numOfEntries uint
+	numOfDeleted uint
+}
+
+type bucketOf[K comparable, V any] struct {
+	first atomic.Pointer[entryOf[K, V]]
+}
+
+type entryOf[K comparable, V any] struct {
+	key     K
+	value   atomic.Pointer[V]
+	deleted atomic.Bool
+	next    atomic.Pointer[entryOf[K, V]]
+}
+
+// NewMapOf returns an empty map that maintains the given number of buckets
+// and hashes keys with hasher. hasher is given a random per-map seed so that
+// callers can plug fast, type-specific hash functions (e.g. xxhash for
+// strings, integer mixing for numeric keys) without implementing their own
+// seeding.
+func NewMapOf[K comparable, V any](capacity uint, hasher func(key K, seed uint64) uint64) *MapOf[K, V] {
+	if capacity == 0 {
+		capacity = iniCapacity
+	}
+	buckets := make([]*bucketOf[K, V], capacity)
+	for i := range buckets {
+		buckets[i] = &bucketOf[K, V]{}
+	}
+	return &MapOf[K, V]{
+		hasher:  hasher,
+		seed:    rand.Uint64(),
+		buckets: buckets,
+	}
+}
+
+// findEntry returns the bucket and the entry with the given key and true if
+// the key exists. Otherwise, it returns the bucket with the given key, a nil
+// entry, and false.
+func (m *MapOf[K, V]) findEntry(key K) (b *bucketOf[K, V], e *entryOf[K, V], ok bool) {
+	i := m.hasher(key, m.seed) % uint64(len(m.buckets))
+	b = m.buckets[i]
+	for e = b.first.Load(); e != nil; e = e.next.Load() {
+		if e.key == key {
+			return b, e, true
+		}
+	}
+	return b, nil, false
+}
+
+// Load returns the value associated with the given key and true if the key
+// exists. Otherwise, it returns the zero value of V and false.
+func (m *MapOf[K, V]) Load(key K) (value V, ok bool) {
+	if _, e, found := m.findEntry(key); found {
+		if !e.deleted.Load() {
+			return *e.value.Load(), true
+		}
+	}
+	var zero V
+	return zero, false
+}
+
+// Store sets the given value to the given key.
+func (m *MapOf[K, V]) Store(key K, value V) {
+	m.mu.Lock()
+	m.storeLocked(key, value)
+	m.mu.Unlock()
+}
+
+// Delete logically removes the given key and its associated value.
+func (m *MapOf[K, V]) Delete(key K) {
+	m.mu.Lock()
+	m.deleteLocked(key)
+	m.mu.Unlock()
+}
+
+// LoadOrStore returns the existing value for the key if present. Otherwise,
+// it stores and returns the given value. The loaded result is true if the
+// value was loaded, false if stored.
+func (m *MapOf[K, V]) LoadOrStore(key K, value V) (actual V, loaded bool) {
+	if actual, loaded = m.Load(key); loaded {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if actual, loaded = m.Load(key); loaded {
+		return
+	}
+	m.storeLocked(key, value)
+	return value, false
+}
+
+// LoadAndDelete removes the value for a key, returning the previous value if
+// any. The loaded result reports whether the key was present.
+func (m *MapOf[K, V]) LoadAndDelete(key K) (value V, loaded bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	value, loaded = m.Load(key)
+	if loaded {
+		m.deleteLocked(key)
+	}
+	return
+}
+
+// Compute looks up key, passes the current value and whether it was present
+// to remapping, and applies the result while still holding m's update mutex,
+// so the read and the resulting Store or Delete happen as one update
+// operation with respect to other writers. actual and ok report the value
+// and presence after remapping runs: the stored newValue and true, or the
+// zero value of V and false if delete is true.
+func (m *MapOf[K, V]) Compute(
+	key K,
+	remapping func(oldValue V, loaded bool) (newValue V, delete bool),
+) (actual V, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	old, loaded := m.Load(key)
+	newValue, del := remapping(old, loaded)
+	if del {
+		if loaded {
+			m.deleteLocked(key)
+		}
+		var zero V
+		return zero, false
+	}
+	m.storeLocked(key, newValue)
+	return newValue, true
+}
+
+// Range iteratively applies the given function to each key-value pair until
+// the function returns false.
+func (m *MapOf[K, V]) Range(f func(key K, value V) bool) {
+	for _, b := range m.buckets {
+		for e := b.first.Load(); e != nil; e = e.next.Load() {
+			if e.deleted.Load() {
+				continue
+			}
+			if !f(e.key, *e.value.Load()) {
+				return
+			}
+		}
+	}
+}
+
+// Size returns the number of keys currently stored in the map.
+func (m *MapOf[K, V]) Size() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return int(m.numOfEntries - m.numOfDeleted)
+}
+
+func (m *MapOf[K, V]) storeLocked(key K, value V) {
+	b, e, ok := m.findEntry(key)
+	if ok {
+		if e.deleted.Load() {
+			m.numOfDeleted--
+		}
+		e.value.Store(&value)
+		e.deleted.Store(false) // linearization point
+		return
+	}
+
+	m.numOfEntries++
+	newEntry := &entryOf[K, V]{key: key}
+	newEntry.value.Store(&value)
+	newEntry.next.Store(b.first.Load())
+	b.first.Store(newEntry) // linearization point
+}
+
+func (m *MapOf[K, V]) deleteLocked(key K) {
+	if _, e, ok := m.findEntry(key); ok {
+		if !e.deleted.Load() {
+			m.numOfDeleted++
+		}
+		e.deleted.Store(true) // linearization point
+	}
+}
@@ -0,0 +1,140 @@
+package cmapof_test
+
+import (
+	"testing"
+
+	"github.com/decillion/go-cmap/cmapof"
+)
+
+func hashString(key string, seed uint64) uint64 {
+	var h uint64 = seed
+	for i := 0; i < len(key); i++ {
+		h = h*31 + uint64(key[i])
+	}
+	return h
+}
+
+func TestLoadStoreDelete(t *testing.T) {
+	m := cmapof.NewMapOf[string, int](0, hashString)
+
+	if _, ok := m.Load("a"); ok {
+		t.Fatal("Load on empty map returned ok = true")
+	}
+
+	m.Store("a", 1)
+	if v, ok := m.Load("a"); !ok || v != 1 {
+		t.Fatalf("Load(%q) = %v, %v; want 1, true", "a", v, ok)
+	}
+
+	m.Store("a", 2)
+	if v, ok := m.Load("a"); !ok || v != 2 {
+		t.Fatalf("Load(%q) after overwrite = %v, %v; want 2, true", "a", v, ok)
+	}
+
+	m.Delete("a")
+	if _, ok := m.Load("a"); ok {
+		t.Fatal("Load after Delete returned ok = true")
+	}
+}
+
+func TestLoadOrStore(t *testing.T) {
+	m := cmapof.NewMapOf[string, int](0, hashString)
+
+	actual, loaded := m.LoadOrStore("a", 1)
+	if loaded || actual != 1 {
+		t.Fatalf("LoadOrStore on absent key = %v, %v; want 1, false", actual, loaded)
+	}
+
+	actual, loaded = m.LoadOrStore("a", 2)
+	if !loaded || actual != 1 {
+		t.Fatalf("LoadOrStore on present key = %v, %v; want 1, true", actual, loaded)
+	}
+}
+
+func TestLoadAndDelete(t *testing.T) {
+	m := cmapof.NewMapOf[string, int](0, hashString)
+	m.Store("a", 1)
+
+	v, loaded := m.LoadAndDelete("a")
+	if !loaded || v != 1 {
+		t.Fatalf("LoadAndDelete(%q) = %v, %v; want 1, true", "a", v, loaded)
+	}
+	if _, ok := m.Load("a"); ok {
+		t.Fatal("Load after LoadAndDelete returned ok = true")
+	}
+
+	if _, loaded := m.LoadAndDelete("a"); loaded {
+		t.Fatal("LoadAndDelete on absent key returned loaded = true")
+	}
+}
+
+func TestCompute(t *testing.T) {
+	m := cmapof.NewMapOf[string, int](0, hashString)
+
+	actual, ok := m.Compute("a", func(old int, loaded bool) (int, bool) {
+		if loaded {
+			t.Fatal("remapping saw loaded = true for an absent key")
+		}
+		return 1, false
+	})
+	if !ok || actual != 1 {
+		t.Fatalf("Compute insert = %v, %v; want 1, true", actual, ok)
+	}
+
+	actual, ok = m.Compute("a", func(old int, loaded bool) (int, bool) {
+		if !loaded || old != 1 {
+			t.Fatalf("remapping saw old = %v, %v; want 1, true", old, loaded)
+		}
+		return old + 1, false
+	})
+	if !ok || actual != 2 {
+		t.Fatalf("Compute update = %v, %v; want 2, true", actual, ok)
+	}
+
+	actual, ok = m.Compute("a", func(old int, loaded bool) (int, bool) {
+		return 0, true
+	})
+	if ok || actual != 0 {
+		t.Fatalf("Compute delete = %v, %v; want 0, false", actual, ok)
+	}
+	if _, ok := m.Load("a"); ok {
+		t.Fatal("key still present after Compute delete")
+	}
+}
+
+func TestSizeAndRange(t *testing.T) {
+	m := cmapof.NewMapOf[int, int](0, func(key int, seed uint64) uint64 {
+		return uint64(key) + seed
+	})
+	const n = 1000
+	want := make(map[int]int, n)
+	for i := 0; i < n; i++ {
+		m.Store(i, i*i)
+		want[i] = i * i
+	}
+
+	if got := m.Size(); got != n {
+		t.Fatalf("Size() = %d; want %d", got, n)
+	}
+
+	got := make(map[int]int, n)
+	m.Range(func(k, v int) bool {
+		got[k] = v
+		return true
+	})
+	if len(got) != len(want) {
+		t.Fatalf("Range visited %d entries; want %d", len(got), len(want))
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Fatalf("Range entry for %d = %d; want %d", k, got[k], v)
+		}
+	}
+
+	for i := 0; i < n; i += 2 {
+		m.Delete(i)
+	}
+	if got := m.Size(); got != n/2 {
+		t.Fatalf("Size() after deleting half = %d; want %d", got, n/2)
+	}
+}
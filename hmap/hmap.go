@@ -2,10 +2,38 @@
 package hmap
 
 import (
+	"math/bits"
+	"runtime"
 	"sync/atomic"
 	"unsafe"
 )
 
+// fastrand is the runtime's per-goroutine pseudo-random generator, the same
+// one sync.Map and the Go scheduler use for load balancing. Linking against
+// it directly avoids the contention and allocation a mutex-guarded
+// math/rand.Source would add to every stripeFor call.
+//
+//go:linkname fastrand runtime.fastrand
+func fastrand() uint32
+
+// bucketCnt is the number of inline key/value slots a bucket holds before
+// entries spill into an overflow bucket. Together with the meta word, a
+// bucket fits in a single cache line on typical 64-bit platforms, so a
+// lookup that stays within one bucket touches memory once before it has to
+// follow a key pointer.
+const bucketCnt = 3
+
+// emptyTopHash marks a bucket slot as unused in the meta word. Because 0 is
+// reserved for that purpose, a real top hash that happens to be 0 is nudged
+// to 1; see topHash.
+const emptyTopHash = 0
+
+const (
+	minMapCounterLen = 8
+	maxMapCounterLen = 64
+	cacheLineSize    = 64
+)
+
 // Map is a non-resizable hash map. A single update operation and multiple read
 // operations can be executed concurrently on the map, while multiple update
 // operations cannot. In other words, only update operations need an external
@@ -15,35 +43,119 @@ import (
 // operations. StatBuckets and StatEntries are considered to be write
 // operations, while they do not modify the map.
 type Map struct {
-	hasher        func(key interface{}) (hash uint32)
-	buckets       []*bucket
-	numOfEntries  uint
-	numOfDeleted  uint
-	largestBucket uint
+	hasher  func(key interface{}) (hash uint32)
+	buckets []*bucket
+
+	// numOfEntries and numOfDeleted are striped counters: each update is a
+	// plain atomic.AddInt64 on a stripe that stripeFor picks at random for
+	// that call, and StatEntries sums across stripes. This keeps the
+	// counters from becoming a point of contention once writers no longer
+	// share a single external mutex, which striping alone does not solve
+	// but does not stand in the way of either.
+	numOfEntries []counterStripe
+	numOfDeleted []counterStripe
+
+	// largest tracks the key count of the largest bucket chain, updated by
+	// Store as it inserts. This lets StatBuckets answer in O(1) instead of
+	// walking every bucket (and every overflow chain) on each call, which
+	// matters because cmap.Map calls StatBuckets from resizeIfNeeded and
+	// evacuateSome on every Store/Delete, not just when a resize decision is
+	// actually due.
+	largest atomic.Uint32
+}
+
+// counterStripe is one slot of a striped counter. It is padded to a cache
+// line so that two goroutines updating different stripes never fight over
+// the same cache line (false sharing).
+type counterStripe struct {
+	v int64
+	_ [cacheLineSize - 8]byte
+}
+
+// numCounterStripes picks how many stripes a new Map's counters get: GOMAXPROCS
+// rounded up to a power of two, clamped to [minMapCounterLen, maxMapCounterLen].
+func numCounterStripes() int {
+	n := runtime.GOMAXPROCS(0)
+	if n < minMapCounterLen {
+		n = minMapCounterLen
+	}
+	if n > maxMapCounterLen {
+		n = maxMapCounterLen
+	}
+	p := 1
+	for p < n {
+		p <<= 1
+	}
+	return p
+}
+
+// stripeFor picks a stripe out of n (a power of two) for the current call,
+// drawing on the runtime's per-goroutine fastrand state so that concurrent
+// goroutines spread across stripes instead of repeatedly colliding on
+// whichever stripe a fixed, goroutine-independent signal would pick.
+func stripeFor(n int) int {
+	return int(fastrand()) & (n - 1)
+}
+
+func addCounter(stripes []counterStripe, delta int64) {
+	s := &stripes[stripeFor(len(stripes))]
+	atomic.AddInt64(&s.v, delta)
 }
 
+func sumCounter(stripes []counterStripe) uint {
+	var sum int64
+	for i := range stripes {
+		sum += atomic.LoadInt64(&stripes[i].v)
+	}
+	return uint(sum)
+}
+
+// bucket holds up to bucketCnt inline key/value slots plus a meta word that
+// packs one top-hash fingerprint byte per slot. findEntry loads meta once
+// and uses it to rule out non-matching slots before touching any key, the
+// same lookup strategy Go's runtime map uses. overflow chains to another
+// bucket once every slot here is taken.
 type bucket struct {
-	first        unsafe.Pointer // *entry
-	numOfEntries uint
+	meta     uint64
+	entries  [bucketCnt]unsafe.Pointer // *entry, nil if the slot is unused
+	overflow unsafe.Pointer            // *bucket
 }
 
 type entry struct {
 	key   interface{}
 	value unsafe.Pointer // *interface{}
-	next  unsafe.Pointer // *entry
 }
 
-var (
-	deleted  = unsafe.Pointer(new(interface{}))
-	terminal = unsafe.Pointer(new(interface{}))
-)
+var deleted = unsafe.Pointer(new(interface{}))
+
+func (b *bucket) loadMeta() uint64 {
+	return atomic.LoadUint64(&b.meta)
+}
+
+func (b *bucket) loadEntry(slot int) *entry {
+	return (*entry)(atomic.LoadPointer(&b.entries[slot]))
+}
+
+func (b *bucket) storeEntry(slot int, e *entry) {
+	atomic.StorePointer(&b.entries[slot], unsafe.Pointer(e))
+}
+
+// setTopHash publishes th for the given slot. It must run after storeEntry
+// for the same slot, since findEntry treats a matching meta byte as a signal
+// that the slot's entry pointer is already visible.
+func (b *bucket) setTopHash(slot int, th uint8) {
+	shift := uint(slot) * 8
+	mask := uint64(0xff) << shift
+	meta := atomic.LoadUint64(&b.meta)
+	atomic.StoreUint64(&b.meta, meta&^mask|uint64(th)<<shift)
+}
 
-func (b *bucket) loadFirst() (first *entry) {
-	return (*entry)(atomic.LoadPointer(&b.first))
+func (b *bucket) loadOverflow() *bucket {
+	return (*bucket)(atomic.LoadPointer(&b.overflow))
 }
 
-func (b *bucket) storeFirst(first *entry) {
-	atomic.StorePointer(&b.first, unsafe.Pointer(first))
+func (b *bucket) storeOverflow(ob *bucket) {
+	atomic.StorePointer(&b.overflow, unsafe.Pointer(ob))
 }
 
 func (e *entry) loadValue() (value interface{}) {
@@ -54,24 +166,104 @@ func (e *entry) storeValue(value interface{}) {
 	atomic.StorePointer(&e.value, unsafe.Pointer(&value))
 }
 
-func (e *entry) loadNext() (next *entry) {
-	return (*entry)(atomic.LoadPointer(&e.next))
+// topHash derives the one-byte fingerprint used to pre-filter a bucket's
+// slots from a key's full hash.
+func topHash(hash uint32) uint8 {
+	th := uint8(hash >> 24)
+	if th == emptyTopHash {
+		th = 1
+	}
+	return th
 }
 
-func (e *entry) storeNext(next *entry) {
-	atomic.StorePointer(&e.next, unsafe.Pointer(next))
+// broadcast repeats b across all eight bytes of a uint64.
+func broadcast(b uint8) uint64 {
+	return uint64(b) * 0x0101010101010101
+}
+
+// hasZeroByte sets the high bit of every zero byte of w; see "Bit Twiddling
+// Hacks" by Sean Eron Anderson. The subtraction's borrow can also set the
+// high bit of a 0x01 byte that sits above a zero byte, so the result may flag
+// extra bytes but never misses a zero one. Used together with broadcast, it
+// turns "which slots may carry fingerprint th" into one XOR, one
+// subtraction, and two ANDs.
+func hasZeroByte(w uint64) uint64 {
+	return (w - 0x0101010101010101) & ^w & 0x8080808080808080
+}
+
+// populatedLanesMask has the high bit of each of the bucketCnt byte lanes
+// that meta actually uses set, in the same bit layout hasZeroByte produces.
+// The remaining lanes sit at emptyTopHash forever, so without this mask a
+// fingerprint of 1 (forced for every key whose natural top byte is 0) would
+// XOR those always-zero lanes to 0x01, and hasZeroByte's borrow chain turns
+// a zero lane followed by a run of 0x01 lanes into spurious matches there
+// too.
+var populatedLanesMask = func() uint64 {
+	var mask uint64
+	for s := 0; s < bucketCnt; s++ {
+		mask |= uint64(0x80) << (uint(s) * 8)
+	}
+	return mask
+}()
+
+// candidateSlots returns, as a bitmask with one set bit per byte lane, every
+// slot of meta whose fingerprint equals th, possibly along with a few that do
+// not (see hasZeroByte); findEntry compares keys to rule those out.
+func candidateSlots(meta uint64, th uint8) uint64 {
+	return hasZeroByte(meta^broadcast(th)) & populatedLanesMask
 }
 
 // StatBuckets returns the number of buckets and the number of keys in the
-// largest bucket.
+// largest bucket chain. Both are O(1): the bucket count never changes for a
+// non-resizable map, and the largest chain length is maintained incrementally
+// by Store.
 func (m *Map) StatBuckets() (capacity, largest uint) {
-	return uint(len(m.buckets)), m.largestBucket
+	return uint(len(m.buckets)), uint(m.largest.Load())
+}
+
+// chainLen counts the keys in the bucket chain starting at head, including
+// logically deleted ones: a tombstone keeps its slot (and thus its byte in
+// meta) until the chain itself is discarded, so it still counts toward the
+// chain length that bounds lookup cost.
+func chainLen(head *bucket) uint {
+	var n uint
+	for cur := head; cur != nil; cur = cur.loadOverflow() {
+		meta := cur.loadMeta()
+		for s := 0; s < bucketCnt; s++ {
+			if byte(meta>>(uint(s)*8)) != emptyTopHash {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// updateLargest raises m.largest to n if n is bigger. It uses a CAS loop
+// rather than trusting Store's external serialization, mirroring the
+// defensive striping numOfEntries and numOfDeleted already use.
+func (m *Map) updateLargest(n uint) {
+	for {
+		cur := m.largest.Load()
+		if uint32(n) <= cur {
+			return
+		}
+		if m.largest.CompareAndSwap(cur, uint32(n)) {
+			return
+		}
+	}
 }
 
 // StatEntries returns the number of keys physically existing in the map and
 // the number of logically deleted keys.
 func (m *Map) StatEntries() (mapSize, deleted uint) {
-	return m.numOfEntries, m.numOfDeleted
+	return sumCounter(m.numOfEntries), sumCounter(m.numOfDeleted)
+}
+
+// Size returns the number of keys currently stored in the map, i.e. the
+// physical entry count minus the logically deleted ones.
+func (m *Map) Size() uint {
+	mapSize, deleted := m.StatEntries()
+	return mapSize - deleted
 }
 
 // NewMap returns an empty hash map that maintain the given number of buckets.
@@ -80,33 +272,51 @@ func NewMap(capacity uint, hasher func(key interface{}) uint32) (m *Map) {
 	buckets := make([]*bucket, capacity)
 	for i := uint(0); i < capacity; i++ {
 		buckets[i] = &bucket{}
-		sentinel := &entry{key: terminal}
-		buckets[i].storeFirst(sentinel)
 	}
-	return &Map{hasher: hasher, buckets: buckets}
+	n := numCounterStripes()
+	return &Map{
+		hasher:       hasher,
+		buckets:      buckets,
+		numOfEntries: make([]counterStripe, n),
+		numOfDeleted: make([]counterStripe, n),
+	}
 }
 
-// findEntry returns the bucket and the entry with the given key and true if
-// the key exists. Otherwise, it returns the bucket with the given key, the
-// sentinel entry, and false.
-func (m *Map) findEntry(key interface{}) (b *bucket, e *entry, ok bool) {
-	i := m.hasher(key) % uint32(len(m.buckets))
-	b = m.buckets[i]
-	e = b.loadFirst()
+// findEntry returns the hash of key, the bucket and slot it hashes to, the
+// entry with the given key, and true if the key exists. Otherwise, it
+// returns a bucket with a free slot and that slot index (or a nil bucket if
+// every bucket in the chain is full), a nil entry, and false.
+func (m *Map) findEntry(key interface{}) (hash uint32, b *bucket, slot int, e *entry, ok bool) {
+	hash = m.hasher(key)
+	i := hash % uint32(len(m.buckets))
+	th := topHash(hash)
 
-	for e.key != key && e.key != terminal {
-		e = e.loadNext()
-	}
-	if e.key == key {
-		return b, e, true
+	var freeBucket *bucket
+	freeSlot := -1
+	for cur := m.buckets[i]; cur != nil; cur = cur.loadOverflow() {
+		meta := cur.loadMeta()
+		for cand := candidateSlots(meta, th); cand != 0; cand &= cand - 1 {
+			s := bits.TrailingZeros64(cand) / 8
+			if ce := cur.loadEntry(s); ce != nil && ce.key == key {
+				return hash, cur, s, ce, true
+			}
+		}
+		if freeBucket == nil {
+			for s := 0; s < bucketCnt; s++ {
+				if byte(meta>>(uint(s)*8)) == emptyTopHash {
+					freeBucket, freeSlot = cur, s
+					break
+				}
+			}
+		}
 	}
-	return b, e, false
+	return hash, freeBucket, freeSlot, nil, false
 }
 
 // Load returns the value associated with the given key and true if the key
 // exists. Otherwise, it returns nil and false.
 func (m *Map) Load(key interface{}) (value interface{}, ok bool) {
-	if _, e, ok := m.findEntry(key); ok {
+	if _, _, _, e, found := m.findEntry(key); found {
 		if v := e.loadValue(); v != deleted {
 			return v, true
 		}
@@ -119,44 +329,85 @@ func (m *Map) Load(key interface{}) (value interface{}, ok bool) {
 
 // Store sets the given value to the given key.
 func (m *Map) Store(key, value interface{}) {
-	if b, e, ok := m.findEntry(key); ok {
+	hash, b, slot, e, ok := m.findEntry(key)
+	if ok {
 		if v := e.loadValue(); v == deleted {
-			m.numOfDeleted--
+			addCounter(m.numOfDeleted, -1)
 		}
 		e.storeValue(value) // linearization point
-	} else {
-		m.numOfEntries++
-		b.numOfEntries++
-		if b.numOfEntries > m.largestBucket {
-			m.largestBucket++
+		return
+	}
+
+	i := hash % uint32(len(m.buckets))
+	if b == nil {
+		tail := m.buckets[i]
+		for tail.loadOverflow() != nil {
+			tail = tail.loadOverflow()
 		}
-		newEntry := &entry{key: key}
-		newEntry.storeValue(value)
-		newEntry.storeNext(b.loadFirst())
-		b.storeFirst(newEntry) // linearization point
+		b = &bucket{}
+		tail.storeOverflow(b)
+		slot = 0
 	}
+
+	addCounter(m.numOfEntries, 1)
+	newEntry := &entry{key: key}
+	newEntry.storeValue(value)
+	b.storeEntry(slot, newEntry)
+	b.setTopHash(slot, topHash(hash)) // linearization point
+	m.updateLargest(chainLen(m.buckets[i]))
 }
 
 // Delete logically removes the given key and its associated value.
 func (m *Map) Delete(key interface{}) {
-	if _, e, ok := m.findEntry(key); ok {
+	if _, _, _, e, ok := m.findEntry(key); ok {
 		if v := e.loadValue(); v != deleted {
-			m.numOfDeleted++
+			addCounter(m.numOfDeleted, 1)
 		}
 		e.storeValue(deleted) // linearization point
 	}
 }
 
-// Range iteratively applies the given function to each key-value pair until
-// the function returns false.
-func (m *Map) Range(f func(key, value interface{}) bool) {
-	for _, b := range m.buckets {
-		for e := b.loadFirst(); e.key != terminal; e = e.loadNext() {
+// RangeBucket iteratively applies the given function to each key-value pair
+// stored in the i-th bucket chain. It lets callers that maintain several
+// hash maps, such as an incremental resize, migrate one bucket's worth of
+// entries at a time instead of walking the whole map; ordinary callers
+// should use Range instead.
+func (m *Map) RangeBucket(i uint, f func(key, value interface{}) bool) {
+	for cur := m.buckets[i]; cur != nil; cur = cur.loadOverflow() {
+		for s := 0; s < bucketCnt; s++ {
+			e := cur.loadEntry(s)
+			if e == nil {
+				continue
+			}
 			v := e.loadValue()
 			if v == deleted {
 				continue
 			}
-			f(e.key, v)
+			if !f(e.key, v) {
+				return
+			}
+		}
+	}
+}
+
+// Range iteratively applies the given function to each key-value pair until
+// the function returns false.
+func (m *Map) Range(f func(key, value interface{}) bool) {
+	for _, head := range m.buckets {
+		for cur := head; cur != nil; cur = cur.loadOverflow() {
+			for s := 0; s < bucketCnt; s++ {
+				e := cur.loadEntry(s)
+				if e == nil {
+					continue
+				}
+				v := e.loadValue()
+				if v == deleted {
+					continue
+				}
+				if !f(e.key, v) {
+					return
+				}
+			}
 		}
 	}
 }
@@ -0,0 +1,52 @@
+package hmap_test
+
+import (
+	"testing"
+
+	"github.com/decillion/go-cmap/hmap"
+)
+
+// hashLowZero returns the key unchanged as its hash, so every key below
+// 1<<24 has a natural top byte of 0 and therefore forces topHash's th==1
+// case for every single Store. This is the scenario that previously let
+// findEntry's SWAR candidate mask spill into a bucket's unused byte lanes
+// and index out of bounds.
+func hashLowZero(key interface{}) uint32 {
+	return uint32(key.(int))
+}
+
+func TestStoreLoadManyKeysWithTopHashCollisions(t *testing.T) {
+	const n = 1 << 14
+	m := hmap.NewMap(1<<4, hashLowZero)
+
+	for i := 0; i < n; i++ {
+		m.Store(i, i)
+	}
+	for i := 0; i < n; i++ {
+		v, ok := m.Load(i)
+		if !ok || v.(int) != i {
+			t.Fatalf("Load(%d) = %v, %v; want %d, true", i, v, ok, i)
+		}
+	}
+
+	mapSize, numDeleted := m.StatEntries()
+	if mapSize != n || numDeleted != 0 {
+		t.Fatalf("StatEntries() = %d, %d; want %d, 0", mapSize, numDeleted, n)
+	}
+
+	for i := 0; i < n; i += 2 {
+		m.Delete(i)
+	}
+	for i := 0; i < n; i++ {
+		v, ok := m.Load(i)
+		if i%2 == 0 {
+			if ok {
+				t.Fatalf("Load(%d) = %v, true; want false after Delete", i, v)
+			}
+			continue
+		}
+		if !ok || v.(int) != i {
+			t.Fatalf("Load(%d) = %v, %v; want %d, true", i, v, ok, i)
+		}
+	}
+}
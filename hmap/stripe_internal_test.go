@@ -0,0 +1,67 @@
+package hmap
+
+import (
+	"sync"
+	"testing"
+)
+
+// TestStripeForDistributesAcrossGoroutines checks that stripeFor does not
+// collapse concurrent goroutines onto a single stripe, which a signal derived
+// from a fixed, goroutine-independent source (such as a stack address at a
+// shared call depth) would do.
+func TestStripeForDistributesAcrossGoroutines(t *testing.T) {
+	const n = 64
+	const callsPerGoroutine = 8
+
+	seen := make([]int, n)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for g := 0; g < n; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < callsPerGoroutine; i++ {
+				s := stripeFor(n)
+				mu.Lock()
+				seen[s]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	distinct := 0
+	for _, c := range seen {
+		if c > 0 {
+			distinct++
+		}
+	}
+	if distinct < 2 {
+		t.Fatalf("stripeFor(%d) produced only %d distinct stripe(s) across %d goroutines; want more than 1", n, distinct, n)
+	}
+}
+
+// TestAddCounterSumsAcrossStripes checks that concurrent addCounter calls are
+// all reflected in sumCounter regardless of which stripe each call lands on.
+func TestAddCounterSumsAcrossStripes(t *testing.T) {
+	stripes := make([]counterStripe, numCounterStripes())
+
+	const goroutines = 32
+	const perGoroutine = 1000
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perGoroutine; i++ {
+				addCounter(stripes, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	want := uint(goroutines * perGoroutine)
+	if got := sumCounter(stripes); got != want {
+		t.Fatalf("sumCounter() = %d; want %d", got, want)
+	}
+}
@@ -0,0 +1,203 @@
+package cmap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sync/atomic"
+
+	"github.com/decillion/go-cmap/hmap"
+)
+
+// snapshotMagic identifies the stream format written by Snapshot. Restore
+// refuses to read a stream that does not start with it.
+var snapshotMagic = [8]byte{'g', 'o', 'c', 'm', 'a', 'p', 0, 1}
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// maxRestoreCap bounds how many buckets Restore will preallocate off of a
+// stream's size hint, before the CRC32C trailer has verified that hint came
+// from a real Snapshot rather than a corrupted or hostile header. Without
+// this, a single flipped bit could turn sizeHint into a huge number and make
+// the make([]*bucket, capacity) inside hmap.NewMap panic with "makeslice: len
+// out of range" or exhaust memory. An honest snapshot bigger than this just
+// costs Restore some extra overflow-chain length instead of failing; it is
+// not truncated or rejected.
+const maxRestoreCap = 1 << 24
+
+// Snapshot writes a point-in-time dump of m to w: a header (magic, then a
+// uvarint size hint), followed by one [uvarint keyLen][keyBytes][uvarint
+// valLen][valBytes] record per live key, followed by a CRC32C trailer over
+// everything written before it. It briefly takes the update mutex to freeze
+// any in-flight resize, then streams the records via Range, so the whole map
+// is never materialized in memory.
+//
+// enc is called once with (k, nil) to obtain the bytes for a key and once
+// with (nil, v) to obtain the bytes for a value, so a single function can
+// cover both forms with a type switch.
+func (m *Map) Snapshot(w io.Writer, enc func(k, v interface{}) ([]byte, error)) error {
+	m.mu.Lock()
+	atomic.StoreUint32(&m.resize, impossible)
+	m.mu.Unlock()
+	defer atomic.StoreUint32(&m.resize, possible)
+
+	bw := bufio.NewWriter(w)
+	crc := crc32.New(crc32cTable)
+	out := io.MultiWriter(bw, crc)
+
+	if _, err := out.Write(snapshotMagic[:]); err != nil {
+		return fmt.Errorf("cmap: write snapshot header: %w", err)
+	}
+	if err := writeUvarint(out, uint64(m.Size())); err != nil {
+		return fmt.Errorf("cmap: write snapshot size hint: %w", err)
+	}
+
+	var rangeErr error
+	m.Range(func(k, v interface{}) bool {
+		if rangeErr != nil {
+			return false
+		}
+		if rangeErr = writeRecord(out, enc, k, v); rangeErr != nil {
+			return false
+		}
+		return true
+	})
+	if rangeErr != nil {
+		return rangeErr
+	}
+
+	if err := binary.Write(out, binary.BigEndian, crc.Sum32()); err != nil {
+		return fmt.Errorf("cmap: write snapshot trailer: %w", err)
+	}
+	return bw.Flush()
+}
+
+func writeRecord(w io.Writer, enc func(k, v interface{}) ([]byte, error), k, v interface{}) error {
+	keyBytes, err := enc(k, nil)
+	if err != nil {
+		return fmt.Errorf("cmap: encode key: %w", err)
+	}
+	valBytes, err := enc(nil, v)
+	if err != nil {
+		return fmt.Errorf("cmap: encode value: %w", err)
+	}
+	if err := writeUvarint(w, uint64(len(keyBytes))); err != nil {
+		return err
+	}
+	if _, err := w.Write(keyBytes); err != nil {
+		return err
+	}
+	if err := writeUvarint(w, uint64(len(valBytes))); err != nil {
+		return err
+	}
+	if _, err := w.Write(valBytes); err != nil {
+		return err
+	}
+	return nil
+}
+
+func writeUvarint(w io.Writer, v uint64) error {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	_, err := w.Write(buf[:n])
+	return err
+}
+
+// Restore reads a stream produced by Snapshot and returns a new map. It
+// sniffs the header, pre-sizes the underlying hmap.Map from the stream's size
+// hint (capped at maxRestoreCap, since the hint is still unverified at this
+// point), reads exactly that many records (the hint also bounds the record
+// loop, since the trailer that follows the last record is not itself a
+// record), and bulk-inserts each one directly into the map so that reading
+// the stream does not trigger any intermediate resize.
+//
+// dec is called once with a key's bytes to obtain k and once with a value's
+// bytes to obtain v, mirroring how Snapshot's enc is used; each call's
+// unused return value is ignored.
+func Restore(r io.Reader, dec func([]byte) (k, v interface{}, err error)) (*Map, error) {
+	br := bufio.NewReader(r)
+	crc := crc32.New(crc32cTable)
+	in := byteReader{io.TeeReader(br, crc)}
+
+	var magic [8]byte
+	if _, err := io.ReadFull(in, magic[:]); err != nil {
+		return nil, fmt.Errorf("cmap: read snapshot header: %w", err)
+	}
+	if magic != snapshotMagic {
+		return nil, fmt.Errorf("cmap: not a cmap snapshot")
+	}
+	sizeHint, err := binary.ReadUvarint(in)
+	if err != nil {
+		return nil, fmt.Errorf("cmap: read snapshot size hint: %w", err)
+	}
+
+	m := NewMap(DefaultHasher)
+	cap := uint(sizeHint/midLoadFactor + 1)
+	if cap < iniCapacity {
+		cap = iniCapacity
+	}
+	if cap > maxRestoreCap {
+		cap = maxRestoreCap
+	}
+	hm := hmap.NewMap(cap, m.hasher)
+	m.hm.Store(hm)
+
+	for i := uint64(0); i < sizeHint; i++ {
+		keyBytes, err := readRecord(in)
+		if err != nil {
+			return nil, fmt.Errorf("cmap: read snapshot key: %w", err)
+		}
+		valBytes, err := readRecord(in)
+		if err != nil {
+			return nil, fmt.Errorf("cmap: read snapshot value: %w", err)
+		}
+
+		k, _, err := dec(keyBytes)
+		if err != nil {
+			return nil, fmt.Errorf("cmap: decode key: %w", err)
+		}
+		_, v, err := dec(valBytes)
+		if err != nil {
+			return nil, fmt.Errorf("cmap: decode value: %w", err)
+		}
+		hm.Store(k, v)
+	}
+
+	var wantCRC uint32
+	if err := binary.Read(br, binary.BigEndian, &wantCRC); err != nil {
+		return nil, fmt.Errorf("cmap: read snapshot trailer: %w", err)
+	}
+	if crc.Sum32() != wantCRC {
+		return nil, fmt.Errorf("cmap: snapshot checksum mismatch")
+	}
+	return m, nil
+}
+
+func readRecord(r byteReader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r.Reader, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// byteReader adapts an io.Reader, such as the io.TeeReader Restore uses to
+// fold checksum computation into the read path, to the io.ByteReader that
+// binary.ReadUvarint requires.
+type byteReader struct {
+	io.Reader
+}
+
+func (r byteReader) ReadByte() (byte, error) {
+	var b [1]byte
+	if _, err := io.ReadFull(r.Reader, b[:]); err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
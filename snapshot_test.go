@@ -0,0 +1,84 @@
+package cmap_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/decillion/go-cmap"
+)
+
+// codeIntString encodes/decodes the (int key, string value) pairs used by
+// TestSnapshotRestore. It is called once per key (v == nil) and once per
+// value (k == nil), matching the calling convention documented on
+// cmap.Map.Snapshot.
+func codeIntString(k, v interface{}) ([]byte, error) {
+	if v == nil {
+		buf := make([]byte, binary.MaxVarintLen64)
+		n := binary.PutVarint(buf, int64(k.(int)))
+		return buf[:n], nil
+	}
+	return []byte(v.(string)), nil
+}
+
+func decodeIntString(b []byte) (k, v interface{}, err error) {
+	n, consumed := binary.Varint(b)
+	if consumed == len(b) && consumed > 0 {
+		return int(n), nil, nil
+	}
+	return nil, string(b), nil
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	const n = 5000
+
+	m := cmap.NewMap(cmap.DefaultHasher)
+	for i := 0; i < n; i++ {
+		m.Store(i, fmt.Sprintf("value-%d", i))
+	}
+
+	var buf bytes.Buffer
+	if err := m.Snapshot(&buf, codeIntString); err != nil {
+		t.Fatalf("Snapshot() returned error: %v", err)
+	}
+
+	restored, err := cmap.Restore(&buf, decodeIntString)
+	if err != nil {
+		t.Fatalf("Restore() returned error: %v", err)
+	}
+
+	if got := restored.Size(); got != n {
+		t.Fatalf("restored.Size() = %d; want %d", got, n)
+	}
+	for i := 0; i < n; i++ {
+		want := fmt.Sprintf("value-%d", i)
+		got, ok := restored.Load(i)
+		if !ok || got.(string) != want {
+			t.Fatalf("restored.Load(%d) = %v, %v; want %q, true", i, got, ok, want)
+		}
+	}
+}
+
+func TestRestoreRejectsBadMagic(t *testing.T) {
+	if _, err := cmap.Restore(bytes.NewReader([]byte("not a snapshot at all")), decodeIntString); err == nil {
+		t.Fatal("Restore() with a bad header returned a nil error")
+	}
+}
+
+// TestRestoreDoesNotTrustHugeSizeHint sends a header whose size hint is far
+// bigger than the stream actually backs up with records. Restore must not
+// try to preallocate a bucket slice sized directly off that number, since an
+// unverified size hint is exactly what a corrupted or hostile stream can
+// control; it should instead fail cleanly once the (absent) records run out.
+func TestRestoreDoesNotTrustHugeSizeHint(t *testing.T) {
+	var buf bytes.Buffer
+	buf.Write([]byte{'g', 'o', 'c', 'm', 'a', 'p', 0, 1})
+	var hint [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(hint[:], 1<<62)
+	buf.Write(hint[:n])
+
+	if _, err := cmap.Restore(&buf, decodeIntString); err == nil {
+		t.Fatal("Restore() with a huge, unbacked size hint returned a nil error")
+	}
+}